@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Admin endpoints under /api/v1/admin/* are gated on the "admin" capability
+// (see requireCap) regardless of auth mode, and operate across tenants by
+// design — they're for operators, not tenant callers.
+
+type adminTenantsResponse struct {
+	Tenants []string `json:"tenants"`
+}
+
+// handleAdminListTenants handles GET /api/v1/admin/tenants, listing every
+// tenant with at least one page currently cached in memory. A tenant whose
+// pages have all been evicted won't appear until it streams WAL again.
+func (s *PageServer) handleAdminListTenants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	seen := make(map[string]bool)
+	for key := range s.pages {
+		seen[key.Tenant] = true
+	}
+	s.mu.RUnlock()
+
+	tenants := make([]string, 0, len(seen))
+	for t := range seen {
+		tenants = append(tenants, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(adminTenantsResponse{Tenants: tenants})
+}
+
+type adminTruncateWALRequest struct {
+	LSN uint64 `json:"lsn"`
+}
+
+// handleAdminTruncateWAL handles POST /api/v1/admin/truncate_wal, forcing
+// the retention watermark forward to an operator-chosen LSN ahead of the
+// compactor's own schedule. Unlike the compactor, this does not first check
+// that every page's base image has actually been folded up to that LSN, so
+// a caller who sets it too high can make affected pages unmaterializable at
+// older LSNs — it's an escape hatch for reclaiming disk under pressure, not
+// a routine operation.
+func (s *PageServer) handleAdminTruncateWAL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req adminTruncateWALRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	id, _ := identityFromContext(r.Context())
+	log.Printf("admin: %s forcing wal retention to lsn=%d", id.Login, req.LSN)
+	s.advanceSafeLSN(req.LSN)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Status string `json:"status"`
+	}{Status: "success"})
+}
+
+type adminEvictPageRequest struct {
+	Tenant  string `json:"tenant"`
+	SpaceID uint32 `json:"space_id"`
+	PageNo  uint32 `json:"page_no"`
+}
+
+// handleAdminEvictPage handles POST /api/v1/admin/evict_page, dropping a
+// page's chain from memory entirely. Since the base image only lives in
+// memory, this loses it: a read for an lsn the evicted chain could have
+// served now returns ErrPageNotFound (until the page is next written) or
+// ErrPageHistoryUnavailable (once it's written again but the requested lsn
+// predates that write) rather than being silently rebuilt. This is an
+// operator escape hatch for reclaiming memory under pressure, not a
+// transparent cache eviction.
+func (s *PageServer) handleAdminEvictPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req adminEvictPageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	key := pageKey{Tenant: req.Tenant, SpaceID: req.SpaceID, PageNo: req.PageNo}
+	s.mu.Lock()
+	chain, existed := s.pages[key]
+	if existed {
+		delete(s.pages, key)
+		s.evictedFloor[key] = chain.currentLastLSN()
+		chain.mu.RLock()
+		s.metrics.walRecordsInMemory.Add(-int64(len(chain.records)))
+		chain.mu.RUnlock()
+		s.metrics.pagesCached.Set(int64(len(s.pages)))
+	}
+	s.mu.Unlock()
+
+	if !existed {
+		http.Error(w, fmt.Sprintf("no cached page for tenant=%q space=%d page=%d", req.Tenant, req.SpaceID, req.PageNo), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Status string `json:"status"`
+	}{Status: "success"})
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Identity is the caller a request was authenticated as: which tenant it
+// belongs to, its login (for logging/auditing), and the capabilities it
+// holds. Handlers read it back out of the request context via
+// identityFromContext; they never construct one themselves.
+type Identity struct {
+	Tenant string
+	Login  string
+	Caps   []string
+}
+
+func (id Identity) hasCap(cap string) bool {
+	for _, c := range id.Caps {
+		if c == cap {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator resolves an Identity for an incoming request. Selected by
+// the -auth flag.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// noneAuthenticator trusts every caller as a single default tenant with
+// full capabilities. It exists for local development and tests where
+// running tailscaled or distributing shared secrets isn't worth the
+// overhead.
+type noneAuthenticator struct{}
+
+func (noneAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	return Identity{Tenant: "default", Login: "anonymous", Caps: []string{"read", "write", "admin"}}, nil
+}
+
+// sharedSecretAuthenticator accepts any request presenting secret in the
+// Authorization header as "Bearer <secret>", and reads the caller's tenant
+// from X-Tenant-ID (defaulting to "default"). Since the secret is shared by
+// every caller, anyone holding it gets full capabilities; it's meant for
+// trusted internal callers, not per-user isolation.
+type sharedSecretAuthenticator struct {
+	secret string
+}
+
+func (a sharedSecretAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if got == "" || got != a.secret {
+		return Identity{}, errors.New("missing or invalid shared secret")
+	}
+	tenant := r.Header.Get("X-Tenant-ID")
+	if tenant == "" {
+		tenant = "default"
+	}
+	return Identity{Tenant: tenant, Login: "shared-secret", Caps: []string{"read", "write", "admin"}}, nil
+}
+
+// WhoIsResponse is the subset of tailscaled's local API
+// (GET /localapi/v0/whois?addr=) we rely on to identify a caller by its
+// tailnet login, mirroring the shape of tailscale.com/client/tailscale's
+// LocalClient.WhoIs response.
+type WhoIsResponse struct {
+	Node        WhoIsNode        `json:"Node"`
+	UserProfile WhoIsUserProfile `json:"UserProfile"`
+}
+
+type WhoIsNode struct {
+	ComputedName string `json:"ComputedName"`
+}
+
+type WhoIsUserProfile struct {
+	LoginName   string `json:"LoginName"`
+	DisplayName string `json:"DisplayName"`
+}
+
+// WhoIsClient looks up the tailnet identity behind a connection's remote
+// address. It's the narrow surface tailscaleAuthenticator needs, so tests
+// can fake it without a real tailscaled running.
+type WhoIsClient interface {
+	WhoIs(ctx context.Context, remoteAddr string) (*WhoIsResponse, error)
+}
+
+// tailscaledWhoIsClient implements WhoIsClient by talking to tailscaled's
+// local API over its unix socket directly, the same wire protocol
+// tailscale.com/client/tailscale's LocalClient uses, without depending on
+// that module.
+type tailscaledWhoIsClient struct {
+	httpClient *http.Client
+}
+
+// defaultTailscaledSocket is where tailscaled listens on most platforms.
+const defaultTailscaledSocket = "/var/run/tailscale/tailscaled.sock"
+
+func newTailscaledWhoIsClient(socketPath string) *tailscaledWhoIsClient {
+	if socketPath == "" {
+		socketPath = defaultTailscaledSocket
+	}
+	return &tailscaledWhoIsClient{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+func (c *tailscaledWhoIsClient) WhoIs(ctx context.Context, remoteAddr string) (*WhoIsResponse, error) {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+
+	reqURL := "http://local-tailscaled.sock/localapi/v0/whois?addr=" + url.QueryEscape(host)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("tailscaled whois: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tailscaled whois: unexpected status %d", resp.StatusCode)
+	}
+
+	var out WhoIsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decode whois response: %w", err)
+	}
+	return &out, nil
+}
+
+// tailscaleAuthenticator derives a caller's tenant and capabilities from its
+// tailnet identity. Tenant is the tailnet domain portion of the login name
+// (e.g. "alice@example.com" -> tenant "example.com"), so every member of a
+// tailnet shares one tenant by default.
+type tailscaleAuthenticator struct {
+	client      WhoIsClient
+	adminLogins map[string]bool
+}
+
+func (a *tailscaleAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	resp, err := a.client.WhoIs(r.Context(), r.RemoteAddr)
+	if err != nil {
+		return Identity{}, fmt.Errorf("whois %s: %w", r.RemoteAddr, err)
+	}
+
+	login := resp.UserProfile.LoginName
+	if login == "" {
+		return Identity{}, fmt.Errorf("whois %s: no login name on node %q", r.RemoteAddr, resp.Node.ComputedName)
+	}
+
+	tenant := login
+	if i := strings.IndexByte(login, '@'); i >= 0 {
+		tenant = login[i+1:]
+	}
+
+	caps := []string{"read", "write"}
+	if a.adminLogins[login] {
+		caps = append(caps, "admin")
+	}
+	return Identity{Tenant: tenant, Login: login, Caps: caps}, nil
+}
+
+type contextKey int
+
+const identityContextKey contextKey = 0
+
+func withIdentity(r *http.Request, id Identity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityContextKey, id))
+}
+
+func identityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityContextKey).(Identity)
+	return id, ok
+}
+
+// withAuth authenticates r using auth before calling next, rejecting the
+// request with 401 on failure. On success, next sees a request whose
+// context carries the resolved Identity.
+func withAuth(auth Authenticator, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := auth.Authenticate(r)
+		if err != nil {
+			http.Error(w, "unauthenticated: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next(w, withIdentity(r, id))
+	}
+}
+
+// requireCap rejects the request with 403 unless the identity attached by
+// withAuth holds cap. It must be applied inside withAuth, not outside it.
+func requireCap(cap string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := identityFromContext(r.Context())
+		if !ok || !id.hasCap(cap) {
+			http.Error(w, fmt.Sprintf("forbidden: missing capability %q", cap), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// newAuthenticator builds the Authenticator selected by -auth, reading the
+// other auth-related flags it needs.
+func newAuthenticator(mode string) (Authenticator, error) {
+	switch mode {
+	case "none":
+		return noneAuthenticator{}, nil
+	case "shared-secret":
+		if *sharedSecret == "" {
+			return nil, errors.New("-shared-secret is required when -auth=shared-secret")
+		}
+		return sharedSecretAuthenticator{secret: *sharedSecret}, nil
+	case "tailscale":
+		logins := make(map[string]bool)
+		for _, l := range strings.Split(*adminLogins, ",") {
+			if l = strings.TrimSpace(l); l != "" {
+				logins[l] = true
+			}
+		}
+		return &tailscaleAuthenticator{
+			client:      newTailscaledWhoIsClient(*tailscaledSocket),
+			adminLogins: logins,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q (want tailscale, none, or shared-secret)", mode)
+	}
+}
+
+// checkTenant rejects a request that names a tenant explicitly (e.g. for a
+// cross-account debugging tool) that doesn't match the caller's own tenant.
+// Most requests omit the field entirely and are scoped to id.Tenant
+// implicitly.
+func checkTenant(id Identity, requested string) error {
+	if requested != "" && requested != id.Tenant {
+		return fmt.Errorf("tenant %q does not match authenticated tenant %q", requested, id.Tenant)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// batchWorkerPoolSize bounds how many GetPage calls a single get_pages
+// request services concurrently, so one oversized batch can't starve every
+// other request of CPU.
+const batchWorkerPoolSize = 8
+
+// pageBatchAcceptType selects the compact binary framing instead of the
+// default multipart/mixed response.
+const pageBatchAcceptType = "application/x-page-batch"
+
+type getPagesRequest struct {
+	Requests []GetPageRequest `json:"requests"`
+}
+
+// batchPageResult is one part of a get_pages response: the originating
+// request plus what came back, or which way it failed. Order matches the
+// request's Requests slice, not completion order.
+type batchPageResult struct {
+	req    GetPageRequest
+	status string // "success", "not_found", "lsn_ahead", or "forbidden"
+	data   []byte
+	lsn    uint64
+}
+
+// handleGetPages handles POST /api/v1/get_pages, fetching many pages in one
+// round-trip. Unlike get_page, failures are per-part rather than failing
+// the whole batch, since one bad request in a batch of a thousand
+// shouldn't cost the other 999 a retry.
+func (s *PageServer) handleGetPages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, _ := identityFromContext(r.Context())
+
+	var req getPagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	results := s.fetchPagesBatch(id.Tenant, req.Requests)
+
+	if strings.Contains(r.Header.Get("Accept"), pageBatchAcceptType) {
+		writeBatchBinary(w, results)
+		return
+	}
+	writeBatchMultipart(w, results)
+}
+
+// fetchPagesBatch resolves every request in reqs against tenant, running up
+// to batchWorkerPoolSize GetPage calls at once.
+func (s *PageServer) fetchPagesBatch(tenant string, reqs []GetPageRequest) []batchPageResult {
+	results := make([]batchPageResult, len(reqs))
+	sem := make(chan struct{}, batchWorkerPoolSize)
+	var wg sync.WaitGroup
+
+	for i, req := range reqs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, req GetPageRequest) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = s.fetchOnePage(tenant, req)
+		}(i, req)
+	}
+
+	wg.Wait()
+	return results
+}
+
+func (s *PageServer) fetchOnePage(tenant string, req GetPageRequest) batchPageResult {
+	if err := checkTenant(Identity{Tenant: tenant}, req.TenantID); err != nil {
+		return batchPageResult{req: req, status: "forbidden"}
+	}
+
+	data, lsn, err := s.GetPage(tenant, req.SpaceID, req.PageNo, req.LSN)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrLSNAhead):
+			return batchPageResult{req: req, status: "lsn_ahead"}
+		default:
+			return batchPageResult{req: req, status: "not_found"}
+		}
+	}
+	return batchPageResult{req: req, status: "success", data: data, lsn: lsn}
+}
+
+// writeBatchMultipart writes results as multipart/mixed, one part per page
+// with its space/page/lsn/status carried in headers and the raw page bytes
+// (no base64) as the part body.
+func writeBatchMultipart(w http.ResponseWriter, results []batchPageResult) {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+
+	for _, res := range results {
+		header := textproto.MIMEHeader{}
+		header.Set("X-Space-ID", strconv.FormatUint(uint64(res.req.SpaceID), 10))
+		header.Set("X-Page-No", strconv.FormatUint(uint64(res.req.PageNo), 10))
+		header.Set("X-Page-LSN", strconv.FormatUint(res.lsn, 10))
+		header.Set("X-Status", res.status)
+
+		pw, err := mw.CreatePart(header)
+		if err != nil {
+			log.Printf("get_pages: failed to create multipart part: %v", err)
+			return
+		}
+		if _, err := pw.Write(res.data); err != nil {
+			log.Printf("get_pages: failed to write part body: %v", err)
+			return
+		}
+	}
+	if err := mw.Close(); err != nil {
+		log.Printf("get_pages: failed to close multipart writer: %v", err)
+	}
+}
+
+// writeBatchBinary writes results as [u32 count] followed by, per result,
+// [u32 space][u32 page][u64 lsn][u32 len][bytes], matching the documented
+// x-page-batch framing exactly. It carries no separate status field: a
+// failed part (not_found, lsn_ahead, forbidden) has lsn=0 and len=0. A
+// successful part for an lsn older than the page's first record also has
+// lsn=0 and len=0, so the two can't be told apart in this framing; the
+// multipart/mixed response carries the full status string for each part.
+func writeBatchBinary(w http.ResponseWriter, results []batchPageResult) {
+	w.Header().Set("Content-Type", pageBatchAcceptType)
+
+	var countBuf [4]byte
+	binary.BigEndian.PutUint32(countBuf[:], uint32(len(results)))
+	w.Write(countBuf[:])
+
+	for _, res := range results {
+		var hdr [20]byte // space(4) + page(4) + lsn(8) + len(4)
+		binary.BigEndian.PutUint32(hdr[0:4], res.req.SpaceID)
+		binary.BigEndian.PutUint32(hdr[4:8], res.req.PageNo)
+		binary.BigEndian.PutUint64(hdr[8:16], res.lsn)
+		binary.BigEndian.PutUint32(hdr[16:20], uint32(len(res.data)))
+
+		if _, err := w.Write(hdr[:]); err != nil {
+			return
+		}
+		if _, err := w.Write(res.data); err != nil {
+			return
+		}
+	}
+}
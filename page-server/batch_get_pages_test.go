@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestPageServer(t *testing.T) *PageServer {
+	t.Helper()
+	store, err := newLocalWALStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("newLocalWALStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	s, err := NewPageServer(store, NewRegistry())
+	if err != nil {
+		t.Fatalf("NewPageServer: %v", err)
+	}
+	return s
+}
+
+func doGetPages(t *testing.T, s *PageServer, body getPagesRequest, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	raw, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/get_pages", bytes.NewReader(raw))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	req = withIdentity(req, Identity{Tenant: "t1"})
+
+	rec := httptest.NewRecorder()
+	s.handleGetPages(rec, req)
+	return rec
+}
+
+func TestHandleGetPagesMultipart(t *testing.T) {
+	s := newTestPageServer(t)
+	if err := s.ingestWAL(WALRecord{LSN: 1, SpaceID: 1, PageNo: 1, Tenant: "t1", WALData: []byte("hello")}); err != nil {
+		t.Fatalf("ingestWAL: %v", err)
+	}
+
+	rec := doGetPages(t, s, getPagesRequest{Requests: []GetPageRequest{
+		{SpaceID: 1, PageNo: 1, LSN: 1},
+		{SpaceID: 9, PageNo: 9, LSN: 1},
+	}}, "")
+
+	_, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("parse content-type: %v", err)
+	}
+	mr := multipart.NewReader(rec.Body, params["boundary"])
+
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("first part: %v", err)
+	}
+	data, err := io.ReadAll(part)
+	if err != nil {
+		t.Fatalf("read first part: %v", err)
+	}
+	if string(data) != "hello" || part.Header.Get("X-Status") != "success" || part.Header.Get("X-Page-LSN") != "1" {
+		t.Fatalf("first part = data=%q status=%q lsn=%q, want hello/success/1", data, part.Header.Get("X-Status"), part.Header.Get("X-Page-LSN"))
+	}
+
+	part, err = mr.NextPart()
+	if err != nil {
+		t.Fatalf("second part: %v", err)
+	}
+	data, err = io.ReadAll(part)
+	if err != nil {
+		t.Fatalf("read second part: %v", err)
+	}
+	if len(data) != 0 || part.Header.Get("X-Status") != "not_found" {
+		t.Fatalf("second part = data=%q status=%q, want empty/not_found", data, part.Header.Get("X-Status"))
+	}
+
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Fatalf("expected exactly two parts, got err=%v", err)
+	}
+}
+
+func TestHandleGetPagesBinary(t *testing.T) {
+	s := newTestPageServer(t)
+	if err := s.ingestWAL(WALRecord{LSN: 1, SpaceID: 1, PageNo: 1, Tenant: "t1", WALData: []byte("hello")}); err != nil {
+		t.Fatalf("ingestWAL: %v", err)
+	}
+
+	rec := doGetPages(t, s, getPagesRequest{Requests: []GetPageRequest{
+		{SpaceID: 1, PageNo: 1, LSN: 1},
+		{SpaceID: 9, PageNo: 9, LSN: 1},
+	}}, pageBatchAcceptType)
+
+	if ct := rec.Header().Get("Content-Type"); ct != pageBatchAcceptType {
+		t.Fatalf("Content-Type = %q, want %q", ct, pageBatchAcceptType)
+	}
+
+	body := rec.Body.Bytes()
+	if len(body) < 4 {
+		t.Fatalf("body too short: %d bytes", len(body))
+	}
+	count := binary.BigEndian.Uint32(body[:4])
+	if count != 2 {
+		t.Fatalf("count = %d, want 2", count)
+	}
+	body = body[4:]
+
+	readEntry := func() (space, page uint32, lsn uint64, data []byte) {
+		t.Helper()
+		if len(body) < 20 {
+			t.Fatalf("entry header truncated: %d bytes left", len(body))
+		}
+		space = binary.BigEndian.Uint32(body[0:4])
+		page = binary.BigEndian.Uint32(body[4:8])
+		lsn = binary.BigEndian.Uint64(body[8:16])
+		length := binary.BigEndian.Uint32(body[16:20])
+		body = body[20:]
+		if uint32(len(body)) < length {
+			t.Fatalf("entry body truncated: want %d bytes, have %d", length, len(body))
+		}
+		data = body[:length]
+		body = body[length:]
+		return
+	}
+
+	space, page, lsn, data := readEntry()
+	if space != 1 || page != 1 || lsn != 1 || string(data) != "hello" {
+		t.Fatalf("first entry = space=%d page=%d lsn=%d data=%q, want 1/1/1/hello", space, page, lsn, data)
+	}
+
+	space, page, lsn, data = readEntry()
+	if space != 9 || page != 9 || lsn != 0 || len(data) != 0 {
+		t.Fatalf("second entry = space=%d page=%d lsn=%d data=%q, want 9/9/0/empty (not_found sentinel)", space, page, lsn, data)
+	}
+
+	if len(body) != 0 {
+		t.Fatalf("%d trailing bytes after the documented entries", len(body))
+	}
+}
+
+func TestBatchStatusByteNotInWireFormat(t *testing.T) {
+	// Regression guard for the format bug: the documented framing has no
+	// status byte between lsn and len, so a forbidden result's len field
+	// must start immediately after the 8-byte lsn field, not after an extra
+	// status byte.
+	results := []batchPageResult{{req: GetPageRequest{SpaceID: 1, PageNo: 2}, status: "forbidden"}}
+	w := httptest.NewRecorder()
+	writeBatchBinary(w, results)
+
+	body := w.Body.Bytes()
+	const wantLen = 4 + 20 // count + one header-only entry, no status byte and no data
+	if len(body) != wantLen {
+		t.Fatalf("body length = %d, want %d (space+page+lsn+len, no status byte)", len(body), wantLen)
+	}
+	gotLen := binary.BigEndian.Uint32(body[4+16 : 4+20])
+	if gotLen != 0 {
+		t.Fatalf("len field = %d, want 0", gotLen)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// compactionThreshold is the number of unfolded WAL records a page can
+// accumulate before the background compactor folds its cold tail into a new
+// base image. Keeping this bounded keeps GetPage replay cost roughly
+// constant regardless of how long a page has been live.
+const compactionThreshold = 64
+
+// compactInterval is how often the background compactor sweeps all pages.
+const compactInterval = 30 * time.Second
+
+// runCompactor periodically folds long WAL chains into fresh base images.
+// It exits when stop is closed.
+func (s *PageServer) runCompactor(stop <-chan struct{}) {
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.compactOnce()
+		}
+	}
+}
+
+// compactOnce folds the cold tail of every page chain whose record count
+// exceeds compactionThreshold, then advances the safe-LSN watermark to the
+// lowest retention floor across all pages. A folded chain's floor is its
+// base image LSN, below which its records are already absorbed; a chain
+// that hasn't folded yet (the common case for pages under
+// compactionThreshold records) has no base image to rely on, so its floor
+// is one below its oldest in-memory record instead — otherwise a single
+// page that never accumulates enough records to fold would pin the global
+// watermark at 0 forever and WAL segments would never be reclaimed. A
+// chain with no records at all yet doesn't constrain the watermark.
+func (s *PageServer) compactOnce() {
+	s.mu.RLock()
+	chains := make([]*pageChain, 0, len(s.pages))
+	for _, c := range s.pages {
+		chains = append(chains, c)
+	}
+	s.mu.RUnlock()
+
+	for _, c := range chains {
+		c.mu.RLock()
+		numRecords := len(c.records)
+		var foldLSN uint64
+		if numRecords > compactionThreshold {
+			foldLSN = c.records[numRecords-compactionThreshold/2].LSN
+		}
+		c.mu.RUnlock()
+
+		if foldLSN == 0 {
+			continue
+		}
+		before := numRecords
+		if err := c.foldTo(s.redo, foldLSN); err != nil {
+			log.Printf("compactor: failed to fold page: %v", err)
+			continue
+		}
+		c.mu.RLock()
+		folded := before - len(c.records)
+		c.mu.RUnlock()
+		s.metrics.walRecordsInMemory.Add(-int64(folded))
+	}
+
+	var safeLSN uint64
+	first := true
+	for _, c := range chains {
+		c.mu.RLock()
+		floor, ok := c.baseLSN, true
+		switch {
+		case c.baseLSN > 0:
+			// Already folded; records at or below baseLSN are absorbed.
+		case len(c.records) > 0:
+			floor = c.records[0].LSN - 1
+		default:
+			ok = false
+		}
+		c.mu.RUnlock()
+
+		if !ok {
+			continue
+		}
+		if first || floor < safeLSN {
+			safeLSN = floor
+			first = false
+		}
+	}
+	if !first && safeLSN > 0 {
+		s.advanceSafeLSN(safeLSN)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// pageServerMetrics holds the handles PageServer updates directly as it
+// serves requests, mirroring the flat, per-name counters used by
+// tailscale's util/clientmetric rather than a labeled vector, since each of
+// these has exactly one dimension worth tracking.
+type pageServerMetrics struct {
+	getPageHits               *Counter
+	getPageMisses             *Counter
+	getPageLSNAhead           *Counter
+	getPageHistoryUnavailable *Counter
+	streamWALRecordsTotal     *Counter
+	streamWALBytesTotal       *Counter
+
+	walLastAppliedLSN  *Gauge
+	walRecordsInMemory *Gauge
+	pagesCached        *Gauge
+
+	pageReplayDepth *Histogram
+}
+
+func newPageServerMetrics(reg *Registry) *pageServerMetrics {
+	return &pageServerMetrics{
+		getPageHits:               reg.Counter("page_server_get_page_hits_total", "get_page calls that found a materializable page"),
+		getPageMisses:             reg.Counter("page_server_get_page_misses_total", "get_page calls for a page with no history"),
+		getPageLSNAhead:           reg.Counter("page_server_get_page_lsn_ahead_total", "get_page calls rejected because the requested lsn hasn't been applied yet"),
+		getPageHistoryUnavailable: reg.Counter("page_server_get_page_history_unavailable_total", "get_page calls rejected because the history the requested lsn needs is no longer in memory (compacted into a newer base image or dropped by an admin eviction)"),
+		streamWALRecordsTotal:     reg.Counter("page_server_stream_wal_records_total", "WAL records ingested across all streaming endpoints"),
+		streamWALBytesTotal:       reg.Counter("page_server_stream_wal_bytes_total", "WAL payload bytes ingested across all streaming endpoints"),
+
+		walLastAppliedLSN:  reg.Gauge("page_server_wal_last_applied_lsn", "Highest LSN applied to any page so far"),
+		walRecordsInMemory: reg.Gauge("page_server_wal_records_in_memory", "Unfolded WAL records currently held across all page chains"),
+		pagesCached:        reg.Gauge("page_server_pages_cached", "Distinct (tenant, space_id, page_no) pages with a chain in memory"),
+
+		pageReplayDepth: reg.Histogram("page_server_page_replay_depth", "Number of WAL records replayed to materialize a page", defaultDepthBuckets),
+	}
+}
+
+// statusWriter wraps an http.ResponseWriter to capture the status code
+// written, while staying transparent to the handler underneath: Flush and
+// Unwrap are forwarded so streaming handlers (stream_wal_v2,
+// subscribe_wal) still see a Flusher, and http.ResponseController can still
+// reach the underlying connection through Unwrap to enable full duplex.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.status = code
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Instrument wraps an http.HandlerFunc so every call to it is counted,
+// timed and tracked while in flight, under the given name. It's applied
+// uniformly to each /api/v1/* route in main so latency, status-code
+// breakdown and in-flight counts are collected the same way regardless of
+// what the handler itself does.
+func Instrument(name string, reg *Registry, next http.HandlerFunc) http.HandlerFunc {
+	inFlight := reg.GaugeVec("page_server_http_in_flight_requests", "In-flight HTTP requests by handler", "handler").WithLabelValues(name)
+	duration := reg.HistogramVec("page_server_http_request_duration_seconds", "HTTP handler latency by handler", defaultLatencyBuckets, "handler").WithLabelValues(name)
+	requests := reg.CounterVec("page_server_http_requests_total", "HTTP requests by handler and status code", "handler", "code")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		next(sw, r)
+		duration.Observe(time.Since(start).Seconds())
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		requests.WithLabelValues(name, strconv.Itoa(status)).Inc()
+	}
+}
+
+// handleMetrics serves the registry's metrics in Prometheus text exposition
+// format.
+func handleMetrics(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		reg.WriteText(w)
+	}
+}
@@ -1,28 +1,67 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 var (
-	port = flag.Int("port", 8080, "The server port")
+	port       = flag.Int("port", 8080, "The server port")
+	walDir     = flag.String("wal-dir", "./wal-data", "Directory for local WAL segments")
+	walSegment = flag.Int64("wal-segment-bytes", defaultSegmentMaxBytes, "Maximum size of a single WAL segment before it is sealed")
+
+	authMode         = flag.String("auth", "none", "Caller authentication mode: tailscale, none, or shared-secret")
+	sharedSecret     = flag.String("shared-secret", "", "Shared secret callers must present as a Bearer token when -auth=shared-secret")
+	tailscaledSocket = flag.String("tailscaled-socket", defaultTailscaledSocket, "Path to tailscaled's local API socket when -auth=tailscale")
+	adminLogins      = flag.String("admin-logins", "", "Comma-separated tailnet logins granted the admin capability when -auth=tailscale")
 )
 
-// PageServer implements the HTTP Page Server
+// shutdownTimeout bounds how long we wait for in-flight requests (including
+// long-lived streams) to drain on SIGINT/SIGTERM before forcing shutdown.
+const shutdownTimeout = 30 * time.Second
+
+// PageServer implements the HTTP Page Server. Each page is tracked as a
+// pageChain (a base image plus the WAL records layered on top of it), keyed
+// by (tenant, space_id, page_no) so tenants are isolated even if they
+// happen to use the same space/page numbers, and GetPage can materialize
+// any LSN on demand instead of returning whatever raw bytes happen to be
+// cached. Durability comes from walStore, which persists every record so
+// state survives a restart.
 type PageServer struct {
-	// In-memory page cache (in production, this would be backed by object storage)
-	pages map[string][]byte
+	pages map[pageKey]*pageChain
 	mu    sync.RWMutex
-	
-	// WAL storage (in production, this would be persistent)
-	walRecords []WALRecord
-	walMu      sync.RWMutex
+
+	// evictedFloor records the lastLSN an evicted page had when its chain
+	// was dropped, keyed by page. chainFor consults it when recreating a
+	// chain for a key it doesn't find in pages, so the new chain starts out
+	// knowing it can't vouch for history at or before that LSN (see
+	// pageChain.historyIncomplete). The entry is consumed (removed) as soon
+	// as it's copied onto the new chain.
+	evictedFloor map[pageKey]uint64
+
+	redo     Redo
+	walStore WALStore
+	wal      *walBroadcaster
+	metrics  *pageServerMetrics
+
+	safeLSN   uint64
+	safeLSNMu sync.Mutex
+
+	stopCompactor chan struct{}
 }
 
 // Request/Response structures
@@ -30,6 +69,10 @@ type GetPageRequest struct {
 	SpaceID uint32 `json:"space_id"`
 	PageNo  uint32 `json:"page_no"`
 	LSN     uint64 `json:"lsn"`
+	// TenantID is optional; if set it must match the caller's authenticated
+	// tenant; requests normally omit it and are scoped to that tenant
+	// implicitly.
+	TenantID string `json:"tenant_id,omitempty"`
 }
 
 type GetPageResponse struct {
@@ -44,6 +87,9 @@ type StreamWALRequest struct {
 	WALData string `json:"wal_data"` // Base64 encoded
 	SpaceID uint32 `json:"space_id,omitempty"`
 	PageNo  uint32 `json:"page_no,omitempty"`
+	// TenantID is optional; if set it must match the caller's authenticated
+	// tenant.
+	TenantID string `json:"tenant_id,omitempty"`
 }
 
 type StreamWALResponse struct {
@@ -57,6 +103,7 @@ type WALRecord struct {
 	WALData []byte
 	SpaceID uint32
 	PageNo  uint32
+	Tenant  string
 }
 
 type PingResponse struct {
@@ -64,11 +111,125 @@ type PingResponse struct {
 	Version string `json:"version"`
 }
 
-func NewPageServer() *PageServer {
-	return &PageServer{
-		pages:      make(map[string][]byte),
-		walRecords: make([]WALRecord, 0),
+// NewPageServer builds a PageServer backed by store, recovering any
+// previously durable WAL records before accepting requests. Metrics are
+// recorded against reg, which the caller owns; tests can pass their own
+// Registry to assert on counter values directly.
+func NewPageServer(store WALStore, reg *Registry) (*PageServer, error) {
+	s := &PageServer{
+		pages:         make(map[pageKey]*pageChain),
+		evictedFloor:  make(map[pageKey]uint64),
+		redo:          overwriteRedo{},
+		walStore:      store,
+		wal:           newWALBroadcaster(),
+		metrics:       newPageServerMetrics(reg),
+		stopCompactor: make(chan struct{}),
+	}
+
+	recs, err := store.Recover()
+	if err != nil {
+		return nil, fmt.Errorf("recover wal: %w", err)
+	}
+	var maxLSN uint64
+	for _, rec := range recs {
+		s.chainFor(pageKey{Tenant: rec.Tenant, SpaceID: rec.SpaceID, PageNo: rec.PageNo}).appendRecord(rec)
+		if rec.LSN > maxLSN {
+			maxLSN = rec.LSN
+		}
 	}
+	log.Printf("Recovered %d WAL records across %d pages", len(recs), len(s.pages))
+	s.metrics.walLastAppliedLSN.Set(int64(maxLSN))
+	s.metrics.walRecordsInMemory.Set(int64(len(recs)))
+	s.metrics.pagesCached.Set(int64(len(s.pages)))
+
+	go s.runCompactor(s.stopCompactor)
+	return s, nil
+}
+
+// chainFor returns the pageChain for key, creating it if this is the first
+// time the page has been seen (or the first time since an admin eviction
+// dropped its predecessor, in which case the new chain starts out
+// historyIncomplete so materialize won't claim success for lsns it can't
+// actually account for).
+func (s *PageServer) chainFor(key pageKey) *pageChain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chain, exists := s.pages[key]
+	if !exists {
+		chain = &pageChain{}
+		if floor, evicted := s.evictedFloor[key]; evicted {
+			chain.historyIncomplete = true
+			chain.historyFloor = floor
+			delete(s.evictedFloor, key)
+		}
+		s.pages[key] = chain
+		s.metrics.pagesCached.Set(int64(len(s.pages)))
+	}
+	return chain
+}
+
+// advanceSafeLSN moves the retention watermark forward and asks walStore to
+// drop segments entirely below it. It never moves backward.
+func (s *PageServer) advanceSafeLSN(lsn uint64) {
+	s.safeLSNMu.Lock()
+	if lsn <= s.safeLSN {
+		s.safeLSNMu.Unlock()
+		return
+	}
+	s.safeLSN = lsn
+	s.safeLSNMu.Unlock()
+
+	if err := s.walStore.Retain(lsn); err != nil {
+		log.Printf("wal: retention to lsn %d failed: %v", lsn, err)
+	}
+}
+
+// GetPage reconstructs the bytes of (space, page) as of lsn: the newest base
+// image with page_lsn <= lsn, replayed forward through every WAL record with
+// record.LSN <= lsn in LSN order. It returns ErrLSNAhead if lsn is newer than
+// anything applied so far, ErrPageNotFound if the page has no history at
+// all (including no history since a prior eviction), and
+// ErrPageHistoryUnavailable if the page has history but not the part of it
+// lsn would need (folded into a newer base image by the compactor, or
+// dropped by an admin eviction).
+func (s *PageServer) GetPage(tenant string, space, page uint32, lsn uint64) ([]byte, uint64, error) {
+	// walLastAppliedLSN holds the highest LSN ingested or recovered across
+	// all pages, i.e. the server-wide applied watermark.
+	if lsn > uint64(s.metrics.walLastAppliedLSN.Value()) {
+		s.metrics.getPageLSNAhead.Inc()
+		return nil, 0, ErrLSNAhead
+	}
+
+	s.mu.RLock()
+	chain, exists := s.pages[pageKey{Tenant: tenant, SpaceID: space, PageNo: page}]
+	s.mu.RUnlock()
+
+	if !exists {
+		s.metrics.getPageMisses.Inc()
+		return nil, 0, ErrPageNotFound
+	}
+
+	// A page with no record above its own lastLSN is unchanged at any later
+	// lsn the server has applied.
+	if last := chain.currentLastLSN(); lsn > last {
+		lsn = last
+	}
+
+	data, pageLSN, depth, err := chain.materialize(s.redo, lsn)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrLSNAhead):
+			s.metrics.getPageLSNAhead.Inc()
+		case errors.Is(err, ErrPageHistoryUnavailable):
+			s.metrics.getPageHistoryUnavailable.Inc()
+		}
+		return nil, 0, err
+	}
+
+	s.metrics.getPageHits.Inc()
+	s.metrics.pageReplayDepth.Observe(float64(depth))
+	return data, pageLSN, nil
 }
 
 // HTTP Handlers
@@ -79,88 +240,157 @@ func (s *PageServer) handleGetPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	id, _ := identityFromContext(r.Context())
+
 	var req GetPageRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	if err := checkTenant(id, req.TenantID); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
-	key := fmt.Sprintf("%d:%d", req.SpaceID, req.PageNo)
-	
-	s.mu.RLock()
-	pageData, exists := s.pages[key]
-	s.mu.RUnlock()
-	
-	if !exists {
-		resp := GetPageResponse{
-			Status: "error",
-			Error:  fmt.Sprintf("Page not found: space=%d page=%d", req.SpaceID, req.PageNo),
+	pageData, pageLSN, err := s.GetPage(id.Tenant, req.SpaceID, req.PageNo, req.LSN)
+	if err != nil {
+		status := http.StatusNotFound
+		msg := fmt.Sprintf("Page not found: space=%d page=%d", req.SpaceID, req.PageNo)
+		switch {
+		case errors.Is(err, ErrLSNAhead):
+			status = http.StatusConflict
+			msg = fmt.Sprintf("Requested lsn %d is ahead of last applied lsn; retry later", req.LSN)
+		case errors.Is(err, ErrPageHistoryUnavailable):
+			status = http.StatusGone
+			msg = fmt.Sprintf("Requested lsn %d is older than the page history still held in memory: space=%d page=%d", req.LSN, req.SpaceID, req.PageNo)
 		}
+		resp := GetPageResponse{Status: "error", Error: msg}
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
+		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(resp)
 		return
 	}
-	
+
 	// Base64 encode page data
 	pageDataB64 := base64.StdEncoding.EncodeToString(pageData)
-	
+
 	resp := GetPageResponse{
 		Status:   "success",
 		PageData: pageDataB64,
-		PageLSN:  req.LSN, // In production, return actual page LSN
+		PageLSN:  pageLSN,
 	}
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// ingestWAL persists a single already-decoded WAL record through walStore
+// (see WALStore.Append for when that is crash-safe) and applies it, then
+// broadcasts it to any subscribe_wal tailers. It is the shared
+// core behind handleStreamWAL, handleStreamWALV2 and any future ingestion
+// path, so every one of them sees the same durability and fan-out behavior.
+func (s *PageServer) ingestWAL(record WALRecord) error {
+	if err := s.walStore.Append(record); err != nil {
+		return fmt.Errorf("failed to persist wal record: %w", err)
+	}
+
+	s.chainFor(pageKey{Tenant: record.Tenant, SpaceID: record.SpaceID, PageNo: record.PageNo}).appendRecord(record)
+	s.wal.publish(record)
+
+	s.metrics.streamWALRecordsTotal.Inc()
+	s.metrics.streamWALBytesTotal.Add(uint64(len(record.WALData)))
+	s.metrics.walRecordsInMemory.Add(1)
+	if record.LSN > uint64(s.metrics.walLastAppliedLSN.Value()) {
+		s.metrics.walLastAppliedLSN.Set(int64(record.LSN))
+	}
+
+	log.Printf("Received WAL record: LSN=%d space=%d page=%d len=%d",
+		record.LSN, record.SpaceID, record.PageNo, len(record.WALData))
+	return nil
+}
+
 func (s *PageServer) handleStreamWAL(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	id, _ := identityFromContext(r.Context())
+
 	var req StreamWALRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	if err := checkTenant(id, req.TenantID); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
-	// Decode base64 WAL data
 	walData, err := base64.StdEncoding.DecodeString(req.WALData)
 	if err != nil {
 		http.Error(w, "Invalid base64 WAL data", http.StatusBadRequest)
 		return
 	}
 
-	// Store WAL record
-	record := WALRecord{
-		LSN:     req.LSN,
-		WALData: walData,
-		SpaceID: req.SpaceID,
-		PageNo:  req.PageNo,
+	record := WALRecord{LSN: req.LSN, WALData: walData, SpaceID: req.SpaceID, PageNo: req.PageNo, Tenant: id.Tenant}
+	if err := s.ingestWAL(record); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	s.walMu.Lock()
-	s.walRecords = append(s.walRecords, record)
-	s.walMu.Unlock()
-
-	// In production, we would:
-	// 1. Apply WAL record to affected pages
-	// 2. Update page versions with new LSN
-	// 3. Store pages to object storage
+	resp := StreamWALResponse{Status: "success", LastAppliedLSN: req.LSN}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
 
-	log.Printf("Received WAL record: LSN=%d space=%d page=%d len=%d",
-		req.LSN, req.SpaceID, req.PageNo, len(walData))
+// handleWALSegmentsList handles POST /api/v1/wal_segments, listing every
+// segment the store currently knows about so a replica can decide which
+// ones it still needs to pull.
+func (s *PageServer) handleWALSegmentsList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	resp := StreamWALResponse{
-		Status:         "success",
-		LastAppliedLSN: req.LSN,
+	segments, err := s.walStore.Segments()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list segments: %v", err), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	json.NewEncoder(w).Encode(struct {
+		Segments []SegmentInfo `json:"segments"`
+	}{Segments: segments})
+}
+
+// handleWALSegmentByID handles GET /api/v1/wal_segments/{id}, streaming the
+// raw bytes of a sealed (or active) segment for a replica to pull.
+func (s *PageServer) handleWALSegmentByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/v1/wal_segments/")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid segment id", http.StatusBadRequest)
+		return
+	}
+
+	seg, err := s.walStore.ReadSegment(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Segment not found: %v", err), http.StatusNotFound)
+		return
+	}
+	defer seg.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := io.Copy(w, seg); err != nil {
+		log.Printf("wal_segments: failed streaming segment %d: %v", id, err)
+	}
 }
 
 func (s *PageServer) handlePing(w http.ResponseWriter, r *http.Request) {
@@ -180,22 +410,71 @@ func (s *PageServer) handlePing(w http.ResponseWriter, r *http.Request) {
 
 func main() {
 	flag.Parse()
-	
-	pageServer := NewPageServer()
-	
-	// Register HTTP handlers
-	http.HandleFunc("/api/v1/get_page", pageServer.handleGetPage)
-	http.HandleFunc("/api/v1/stream_wal", pageServer.handleStreamWAL)
-	http.HandleFunc("/api/v1/ping", pageServer.handlePing)
-	
-	log.Printf("Page Server listening on port %d", *port)
+
+	store, err := newLocalWALStore(*walDir, *walSegment)
+	if err != nil {
+		log.Fatalf("failed to open wal store: %v", err)
+	}
+	defer store.Close()
+
+	auth, err := newAuthenticator(*authMode)
+	if err != nil {
+		log.Fatalf("invalid -auth: %v", err)
+	}
+
+	metrics := NewRegistry()
+	pageServer, err := NewPageServer(store, metrics)
+	if err != nil {
+		log.Fatalf("failed to start page server: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/get_page", Instrument("get_page", metrics, withAuth(auth, pageServer.handleGetPage)))
+	mux.HandleFunc("/api/v1/get_pages", Instrument("get_pages", metrics, withAuth(auth, pageServer.handleGetPages)))
+	mux.HandleFunc("/api/v1/stream_wal", Instrument("stream_wal", metrics, withAuth(auth, pageServer.handleStreamWAL)))
+	mux.HandleFunc("/api/v1/stream_wal_v2", Instrument("stream_wal_v2", metrics, withAuth(auth, pageServer.handleStreamWALV2)))
+	mux.HandleFunc("/api/v1/subscribe_wal", Instrument("subscribe_wal", metrics, withAuth(auth, pageServer.handleSubscribeWAL)))
+	mux.HandleFunc("/api/v1/wal_segments", Instrument("wal_segments_list", metrics, withAuth(auth, requireCap("admin", pageServer.handleWALSegmentsList))))
+	mux.HandleFunc("/api/v1/wal_segments/", Instrument("wal_segments_get", metrics, withAuth(auth, requireCap("admin", pageServer.handleWALSegmentByID))))
+	mux.HandleFunc("/api/v1/admin/tenants", Instrument("admin_tenants", metrics, withAuth(auth, requireCap("admin", pageServer.handleAdminListTenants))))
+	mux.HandleFunc("/api/v1/admin/truncate_wal", Instrument("admin_truncate_wal", metrics, withAuth(auth, requireCap("admin", pageServer.handleAdminTruncateWAL))))
+	mux.HandleFunc("/api/v1/admin/evict_page", Instrument("admin_evict_page", metrics, withAuth(auth, requireCap("admin", pageServer.handleAdminEvictPage))))
+	mux.HandleFunc("/api/v1/ping", Instrument("ping", metrics, pageServer.handlePing))
+	mux.HandleFunc("/metrics", handleMetrics(metrics))
+
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", *port), Handler: mux}
+
+	log.Printf("Page Server listening on port %d (auth=%s)", *port, *authMode)
 	log.Printf("Endpoints:")
 	log.Printf("  POST /api/v1/get_page")
+	log.Printf("  POST /api/v1/get_pages")
 	log.Printf("  POST /api/v1/stream_wal")
+	log.Printf("  POST /api/v1/stream_wal_v2")
+	log.Printf("  GET  /api/v1/subscribe_wal")
+	log.Printf("  POST /api/v1/wal_segments")
+	log.Printf("  GET  /api/v1/wal_segments/{id}")
+	log.Printf("  GET  /api/v1/admin/tenants")
+	log.Printf("  POST /api/v1/admin/truncate_wal")
+	log.Printf("  POST /api/v1/admin/evict_page")
 	log.Printf("  GET  /api/v1/ping")
-	
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil); err != nil {
+	log.Printf("  GET  /metrics")
+
+	shutdown := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Printf("shutting down, draining in-flight requests...")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("graceful shutdown failed: %v", err)
+		}
+		close(shutdown)
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("failed to serve: %v", err)
 	}
+	<-shutdown
 }
-
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing value, e.g. a count of requests
+// served. Safe for concurrent use.
+type Counter struct {
+	val uint64
+}
+
+func (c *Counter) Inc() { atomic.AddUint64(&c.val, 1) }
+
+func (c *Counter) Add(delta uint64) { atomic.AddUint64(&c.val, delta) }
+
+func (c *Counter) Value() uint64 { return atomic.LoadUint64(&c.val) }
+
+// Gauge is a value that can move up or down, e.g. the number of pages
+// currently cached. Safe for concurrent use.
+type Gauge struct {
+	val int64
+}
+
+func (g *Gauge) Set(v int64) { atomic.StoreInt64(&g.val, v) }
+
+func (g *Gauge) Add(delta int64) { atomic.AddInt64(&g.val, delta) }
+
+func (g *Gauge) Value() int64 { return atomic.LoadInt64(&g.val) }
+
+// defaultLatencyBuckets are the histogram bucket upper bounds (seconds)
+// used for handler latency; defaultDepthBuckets are used for page-replay
+// depth (record count rather than seconds).
+var (
+	defaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+	defaultDepthBuckets   = []float64{1, 2, 4, 8, 16, 32, 64, 128}
+)
+
+// Histogram tracks the distribution of observed values against a fixed set
+// of bucket upper bounds, plus a running sum and count.
+type Histogram struct {
+	buckets []float64 // ascending upper bounds
+
+	mu     sync.Mutex
+	counts []uint64 // counts[i] = observations with value <= buckets[i]
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sum += v
+	h.count++
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+}
+
+func (h *Histogram) snapshot() (counts []uint64, sum float64, count uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]uint64(nil), h.counts...), h.sum, h.count
+}
+
+// Registry collects named metrics and renders them in Prometheus text
+// exposition format. It is deliberately small and dependency-free (in the
+// spirit of tailscale's util/clientmetric) and is passed around explicitly
+// rather than kept in a package-level global, so tests can construct their
+// own Registry and assert counter values directly.
+type Registry struct {
+	mu         sync.Mutex
+	counters   map[string]*Counter
+	gauges     map[string]*Gauge
+	histograms map[string]*Histogram
+	help       map[string]string
+
+	counterVecs   map[string]*counterVec
+	gaugeVecs     map[string]*gaugeVec
+	histogramVecs map[string]*histogramVec
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:      make(map[string]*Counter),
+		gauges:        make(map[string]*Gauge),
+		histograms:    make(map[string]*Histogram),
+		help:          make(map[string]string),
+		counterVecs:   make(map[string]*counterVec),
+		gaugeVecs:     make(map[string]*gaugeVec),
+		histogramVecs: make(map[string]*histogramVec),
+	}
+}
+
+// Counter returns the named counter, creating it on first use.
+func (r *Registry) Counter(name, help string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.counters[name]; ok {
+		return c
+	}
+	c := &Counter{}
+	r.counters[name] = c
+	r.help[name] = help
+	return c
+}
+
+// Gauge returns the named gauge, creating it on first use.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if g, ok := r.gauges[name]; ok {
+		return g
+	}
+	g := &Gauge{}
+	r.gauges[name] = g
+	r.help[name] = help
+	return g
+}
+
+// Histogram returns the named histogram, creating it with buckets on first
+// use.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h, ok := r.histograms[name]; ok {
+		return h
+	}
+	h := newHistogram(buckets)
+	r.histograms[name] = h
+	r.help[name] = help
+	return h
+}
+
+// counterVec is a family of counters sharing a name and label names, each
+// identified by a distinct set of label values (e.g. handler + status
+// code).
+type counterVec struct {
+	help       string
+	labelNames []string
+
+	mu       sync.Mutex
+	children map[string]*Counter
+	labels   map[string][]string
+}
+
+// CounterVec returns the named counter family, creating it on first use.
+func (r *Registry) CounterVec(name, help string, labelNames ...string) *counterVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v, ok := r.counterVecs[name]; ok {
+		return v
+	}
+	v := &counterVec{help: help, labelNames: labelNames, children: make(map[string]*Counter), labels: make(map[string][]string)}
+	r.counterVecs[name] = v
+	return v
+}
+
+func (v *counterVec) WithLabelValues(values ...string) *Counter {
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c
+	}
+	c := &Counter{}
+	v.children[key] = c
+	v.labels[key] = values
+	return c
+}
+
+type gaugeVec struct {
+	help       string
+	labelNames []string
+
+	mu       sync.Mutex
+	children map[string]*Gauge
+	labels   map[string][]string
+}
+
+// GaugeVec returns the named gauge family, creating it on first use.
+func (r *Registry) GaugeVec(name, help string, labelNames ...string) *gaugeVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v, ok := r.gaugeVecs[name]; ok {
+		return v
+	}
+	v := &gaugeVec{help: help, labelNames: labelNames, children: make(map[string]*Gauge), labels: make(map[string][]string)}
+	r.gaugeVecs[name] = v
+	return v
+}
+
+func (v *gaugeVec) WithLabelValues(values ...string) *Gauge {
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if g, ok := v.children[key]; ok {
+		return g
+	}
+	g := &Gauge{}
+	v.children[key] = g
+	v.labels[key] = values
+	return g
+}
+
+type histogramVec struct {
+	help       string
+	labelNames []string
+	buckets    []float64
+
+	mu       sync.Mutex
+	children map[string]*Histogram
+	labels   map[string][]string
+}
+
+// HistogramVec returns the named histogram family, creating it with buckets
+// on first use.
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labelNames ...string) *histogramVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v, ok := r.histogramVecs[name]; ok {
+		return v
+	}
+	v := &histogramVec{help: help, labelNames: labelNames, buckets: buckets, children: make(map[string]*Histogram), labels: make(map[string][]string)}
+	r.histogramVecs[name] = v
+	return v
+}
+
+func (v *histogramVec) WithLabelValues(values ...string) *Histogram {
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if h, ok := v.children[key]; ok {
+		return h
+	}
+	h := newHistogram(v.buckets)
+	v.children[key] = h
+	v.labels[key] = values
+	return h
+}
+
+func labelPairs(names, values []string) string {
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = fmt.Sprintf("%s=%q", n, values[i])
+	}
+	return strings.Join(parts, ",")
+}
+
+// WriteText renders every registered metric in Prometheus text exposition
+// format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range sortedKeys(r.counters) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, r.help[name], name, name, r.counters[name].Value())
+	}
+	for _, name := range sortedKeysGauge(r.gauges) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, r.help[name], name, name, r.gauges[name].Value())
+	}
+	for _, name := range sortedKeysHist(r.histograms) {
+		writeHistogram(w, name, r.help[name], r.histograms[name])
+	}
+
+	for name, v := range r.counterVecs {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, v.help, name)
+		v.mu.Lock()
+		for _, key := range sortedLabelKeys(v.labels) {
+			fmt.Fprintf(w, "%s{%s} %d\n", name, labelPairs(v.labelNames, v.labels[key]), v.children[key].Value())
+		}
+		v.mu.Unlock()
+	}
+	for name, v := range r.gaugeVecs {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, v.help, name)
+		v.mu.Lock()
+		for _, key := range sortedLabelKeys(v.labels) {
+			fmt.Fprintf(w, "%s{%s} %d\n", name, labelPairs(v.labelNames, v.labels[key]), v.children[key].Value())
+		}
+		v.mu.Unlock()
+	}
+	for name, v := range r.histogramVecs {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, v.help, name)
+		v.mu.Lock()
+		for _, key := range sortedLabelKeys(v.labels) {
+			writeHistogramLabeled(w, name, labelPairs(v.labelNames, v.labels[key]), v.children[key])
+		}
+		v.mu.Unlock()
+	}
+	return nil
+}
+
+func writeHistogram(w io.Writer, name, help string, h *Histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	writeHistogramLabeled(w, name, "", h)
+}
+
+func writeHistogramLabeled(w io.Writer, name, labels string, h *Histogram) {
+	counts, sum, count := h.snapshot()
+	for i, upper := range h.buckets {
+		le := fmt.Sprintf("le=%q", formatFloat(upper))
+		if labels != "" {
+			fmt.Fprintf(w, "%s_bucket{%s,%s} %d\n", name, labels, le, counts[i])
+		} else {
+			fmt.Fprintf(w, "%s_bucket{%s} %d\n", name, le, counts[i])
+		}
+	}
+	infLabels := "le=\"+Inf\""
+	if labels != "" {
+		infLabels = labels + "," + infLabels
+	}
+	fmt.Fprintf(w, "%s_bucket{%s} %d\n", name, infLabels, count)
+	if labels != "" {
+		fmt.Fprintf(w, "%s_sum{%s} %v\n%s_count{%s} %d\n", name, labels, sum, name, labels, count)
+	} else {
+		fmt.Fprintf(w, "%s_sum %v\n%s_count %d\n", name, sum, name, count)
+	}
+}
+
+func formatFloat(f float64) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%f", f), "0"), ".")
+}
+
+func sortedKeys(m map[string]*Counter) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeysGauge(m map[string]*Gauge) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeysHist(m map[string]*Histogram) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedLabelKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
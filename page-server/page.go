@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrLSNAhead is returned by GetPage when the requested LSN is newer than
+// anything the server has applied yet. Callers should retry once more WAL
+// has streamed in.
+var ErrLSNAhead = errors.New("requested lsn is ahead of last applied lsn")
+
+// ErrPageNotFound is returned when no base image or WAL history exists for
+// the requested (space_id, page_no).
+var ErrPageNotFound = errors.New("page not found")
+
+// ErrPageHistoryUnavailable is returned when a page has history but not the
+// part the requested lsn needs: either the compactor has folded the page
+// into a base image newer than lsn (only the newest base image is kept), or
+// the page's chain was evicted and then recreated by a later WAL record and
+// no record of the recreated chain covers lsn. Unlike ErrLSNAhead, retrying
+// won't help: that history is no longer held in memory.
+var ErrPageHistoryUnavailable = errors.New("page history before this lsn is no longer available in memory")
+
+// pageKey identifies a single page within a tenant's space. Tenant is part
+// of the key, not just an access check layered on top, so two tenants using
+// the same (space_id, page_no) never share a chain.
+type pageKey struct {
+	Tenant  string
+	SpaceID uint32
+	PageNo  uint32
+}
+
+// pageChain holds everything needed to materialize one page at any LSN: the
+// newest base image we've folded records into, plus the WAL records applied
+// on top of it. Records are kept sorted by LSN so replay can stop as soon as
+// it passes the requested LSN.
+type pageChain struct {
+	mu sync.RWMutex
+
+	baseImage []byte
+	baseLSN   uint64
+
+	// records are WAL entries sorted ascending by LSN, normally all above
+	// baseLSN; a late record at or below baseLSN is kept but skipped by
+	// materialize, and dropped by the next foldTo.
+	records []WALRecord
+
+	// lastLSN is the highest LSN seen for this page, whether or not it has
+	// been folded into baseImage yet.
+	lastLSN uint64
+
+	// historyIncomplete marks a chain that was recreated after an admin
+	// eviction dropped its predecessor: baseImage is an empty placeholder,
+	// not genuinely the state of the page at LSN 0. historyFloor is the
+	// evicted chain's lastLSN; materialize must not claim success for any
+	// lsn it can't actually account for with a record above that floor.
+	historyIncomplete bool
+	historyFloor      uint64
+}
+
+// appendRecord inserts rec into the chain in LSN order. WAL records for a
+// given page are expected to arrive in order, but we sort defensively since
+// replicas may replay out of order after a reconnect.
+func (c *pageChain) appendRecord(rec WALRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.records = append(c.records, rec)
+	if len(c.records) > 1 && c.records[len(c.records)-2].LSN > rec.LSN {
+		sort.Slice(c.records, func(i, j int) bool { return c.records[i].LSN < c.records[j].LSN })
+	}
+	if rec.LSN > c.lastLSN {
+		c.lastLSN = rec.LSN
+	}
+}
+
+// currentLastLSN returns the highest LSN this chain has seen so far, e.g.
+// for an admin eviction to record as the new floor for a future chain
+// recreated at this key.
+func (c *pageChain) currentLastLSN() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastLSN
+}
+
+// materialize reconstructs the page as of lsn by starting from the newest
+// base image with page_lsn <= lsn and replaying every record with
+// record.LSN <= lsn, in LSN order, through redo. depth is the number of WAL
+// records replayed, for callers that want to track replay cost. Only the
+// newest base image is kept, so an lsn below baseLSN returns
+// ErrPageHistoryUnavailable.
+//
+// If this chain was recreated after an eviction (historyIncomplete) and no
+// record above historyFloor qualifies for lsn, there is no way to know
+// whether the real page had further history below that floor: return
+// ErrPageHistoryUnavailable instead of silently reporting an empty page.
+func (c *pageChain) materialize(redo Redo, lsn uint64) (page []byte, pageLSN uint64, depth int, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if lsn > c.lastLSN {
+		return nil, 0, 0, ErrLSNAhead
+	}
+	if c.historyIncomplete && lsn <= c.historyFloor {
+		return nil, 0, 0, ErrPageHistoryUnavailable
+	}
+	if lsn < c.baseLSN {
+		return nil, 0, 0, ErrPageHistoryUnavailable
+	}
+
+	page = append([]byte(nil), c.baseImage...)
+	pageLSN = c.baseLSN
+
+	for _, rec := range c.records {
+		if rec.LSN <= c.baseLSN {
+			continue
+		}
+		if rec.LSN > lsn {
+			break
+		}
+		applied, err := redo.Apply(page, rec)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		page = applied
+		pageLSN = rec.LSN
+		depth++
+	}
+
+	if c.historyIncomplete && depth == 0 {
+		return nil, 0, 0, ErrPageHistoryUnavailable
+	}
+	return page, pageLSN, depth, nil
+}
+
+// foldTo replaces the base image with page as of foldLSN and drops every
+// record with LSN <= foldLSN, shrinking future replay work. It is the
+// building block the background compactor uses.
+//
+// If this chain is historyIncomplete (recreated after an eviction) and no
+// record above historyFloor qualifies yet, folding would bake the empty
+// placeholder in as a "real" base image at foldLSN; skip the fold instead
+// and wait for a qualifying record to arrive.
+func (c *pageChain) foldTo(redo Redo, foldLSN uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if foldLSN <= c.baseLSN {
+		return nil
+	}
+	if c.historyIncomplete && foldLSN <= c.historyFloor {
+		return nil
+	}
+
+	page := append([]byte(nil), c.baseImage...)
+	pageLSN := c.baseLSN
+	kept := c.records[:0:0]
+	folded := false
+
+	for _, rec := range c.records {
+		if rec.LSN <= c.baseLSN {
+			continue
+		}
+		if rec.LSN > foldLSN {
+			kept = append(kept, rec)
+			continue
+		}
+		applied, err := redo.Apply(page, rec)
+		if err != nil {
+			return err
+		}
+		page = applied
+		pageLSN = rec.LSN
+		folded = true
+	}
+
+	if c.historyIncomplete && !folded {
+		return nil
+	}
+
+	c.baseImage = page
+	c.baseLSN = pageLSN
+	c.records = kept
+	if folded {
+		c.historyIncomplete = false
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPageChainMaterialize(t *testing.T) {
+	c := &pageChain{}
+	c.appendRecord(WALRecord{LSN: 1, WALData: []byte("v1")})
+	c.appendRecord(WALRecord{LSN: 2, WALData: []byte("v2")})
+	c.appendRecord(WALRecord{LSN: 5, WALData: []byte("v5")})
+
+	page, pageLSN, depth, err := c.materialize(overwriteRedo{}, 2)
+	if err != nil {
+		t.Fatalf("materialize(2): %v", err)
+	}
+	if string(page) != "v2" || pageLSN != 2 || depth != 2 {
+		t.Fatalf("materialize(2) = %q, lsn=%d, depth=%d; want %q, lsn=2, depth=2", page, pageLSN, depth, "v2")
+	}
+
+	page, pageLSN, depth, err = c.materialize(overwriteRedo{}, 3)
+	if err != nil {
+		t.Fatalf("materialize(3): %v", err)
+	}
+	if string(page) != "v2" || pageLSN != 2 || depth != 2 {
+		t.Fatalf("materialize(3) = %q, lsn=%d, depth=%d; want the last record <= 3 (v2)", page, pageLSN, depth)
+	}
+
+	if _, _, _, err := c.materialize(overwriteRedo{}, 6); !errors.Is(err, ErrLSNAhead) {
+		t.Fatalf("materialize(6) err = %v, want ErrLSNAhead", err)
+	}
+}
+
+func TestPageChainFoldTo(t *testing.T) {
+	c := &pageChain{}
+	c.appendRecord(WALRecord{LSN: 1, WALData: []byte("v1")})
+	c.appendRecord(WALRecord{LSN: 2, WALData: []byte("v2")})
+	c.appendRecord(WALRecord{LSN: 3, WALData: []byte("v3")})
+
+	if err := c.foldTo(overwriteRedo{}, 2); err != nil {
+		t.Fatalf("foldTo(2): %v", err)
+	}
+	if c.baseLSN != 2 || string(c.baseImage) != "v2" {
+		t.Fatalf("after foldTo(2), baseLSN=%d baseImage=%q; want 2, %q", c.baseLSN, c.baseImage, "v2")
+	}
+	if len(c.records) != 1 || c.records[0].LSN != 3 {
+		t.Fatalf("after foldTo(2), records=%+v; want only lsn=3 kept", c.records)
+	}
+
+	// Materializing at the folded LSN, or above it, still works from the
+	// new base image.
+	page, _, depth, err := c.materialize(overwriteRedo{}, 3)
+	if err != nil {
+		t.Fatalf("materialize(3) after fold: %v", err)
+	}
+	if string(page) != "v3" || depth != 1 {
+		t.Fatalf("materialize(3) after fold = %q depth=%d; want v3 depth=1", page, depth)
+	}
+}
+
+// TestPageChainHistoryIncompleteAfterEviction guards against the eviction
+// bug where a chain recreated after an admin eviction would silently
+// materialize an empty page instead of erroring for an lsn whose history
+// was dropped (see handleAdminEvictPage).
+func TestPageChainHistoryIncompleteAfterEviction(t *testing.T) {
+	c := &pageChain{historyIncomplete: true, historyFloor: 5}
+	c.appendRecord(WALRecord{LSN: 6, WALData: []byte("v6")})
+
+	if _, _, _, err := c.materialize(overwriteRedo{}, 5); !errors.Is(err, ErrPageHistoryUnavailable) {
+		t.Fatalf("materialize(5) err = %v, want ErrPageHistoryUnavailable", err)
+	}
+	if _, _, _, err := c.materialize(overwriteRedo{}, 3); !errors.Is(err, ErrPageHistoryUnavailable) {
+		t.Fatalf("materialize(3) err = %v, want ErrPageHistoryUnavailable", err)
+	}
+
+	page, pageLSN, _, err := c.materialize(overwriteRedo{}, 6)
+	if err != nil {
+		t.Fatalf("materialize(6): %v", err)
+	}
+	if string(page) != "v6" || pageLSN != 6 {
+		t.Fatalf("materialize(6) = %q, lsn=%d; want v6, lsn=6", page, pageLSN)
+	}
+
+	// foldTo below the floor must not be able to bake the empty placeholder
+	// in as a real base image.
+	if err := c.foldTo(overwriteRedo{}, 5); err != nil {
+		t.Fatalf("foldTo(5): %v", err)
+	}
+	if !c.historyIncomplete || c.baseLSN != 0 {
+		t.Fatalf("foldTo(5) mutated an incomplete chain below its floor: historyIncomplete=%v baseLSN=%d", c.historyIncomplete, c.baseLSN)
+	}
+
+	// Folding past a qualifying record clears the incomplete marker.
+	if err := c.foldTo(overwriteRedo{}, 6); err != nil {
+		t.Fatalf("foldTo(6): %v", err)
+	}
+	if c.historyIncomplete {
+		t.Fatalf("foldTo(6) should have cleared historyIncomplete")
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+// Redo knows how to apply a single WAL record to a page image and return the
+// resulting bytes. It is the extension point for plugging in different WAL
+// formats (physical, logical, page-diff, ...) without PageServer needing to
+// know their encoding.
+type Redo interface {
+	Apply(page []byte, rec WALRecord) ([]byte, error)
+}
+
+// overwriteRedo is the default Redo implementation: it treats WALData as a
+// full replacement image for the page. This matches the toy format the rest
+// of the server currently produces; real WAL formats should supply their own
+// Redo that applies a diff instead.
+type overwriteRedo struct{}
+
+func (overwriteRedo) Apply(page []byte, rec WALRecord) ([]byte, error) {
+	return append([]byte(nil), rec.WALData...), nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// maxFrameBytes bounds a single length-prefixed frame on stream_wal_v2,
+// guarding against a misbehaving client claiming an enormous length.
+const maxFrameBytes = 64 << 20
+
+// readFrame reads one length-prefixed frame: a 4-byte big-endian length
+// followed by that many bytes of payload.
+func readFrame(r io.Reader) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	if n > maxFrameBytes {
+		return nil, fmt.Errorf("frame of %d bytes exceeds max %d", n, maxFrameBytes)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// writeFrame writes payload as a 4-byte big-endian length followed by the
+// payload bytes.
+func writeFrame(w io.Writer, payload []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(payload)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// handleStreamWALV2 accepts a chunked stream of length-prefixed
+// StreamWALRequest frames and writes back a length-prefixed
+// StreamWALResponse ack on the same response body as soon as walStore has
+// accepted each record, so a client can pipeline many records without
+// paying one HTTP round-trip per record. The ack does not wait for an
+// fsync: the store batches fsyncs (see WALStore.Append), so an acked record
+// is not guaranteed crash-safe until its batch reaches disk. The frame payload is JSON today; the
+// framing leaves room for a protobuf payload later without changing the
+// wire format.
+func (s *PageServer) handleStreamWALV2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// HTTP/1.1 is half-duplex by default in net/http: writing a response
+	// before the request body is fully read can get the body closed out
+	// from under us. EnableFullDuplex lets us interleave reads of the
+	// incoming frames with writes of their acks on the same connection.
+	if err := http.NewResponseController(w).EnableFullDuplex(); err != nil {
+		http.Error(w, "Full duplex not supported", http.StatusInternalServerError)
+		return
+	}
+
+	id, _ := identityFromContext(r.Context())
+
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		frame, err := readFrame(r.Body)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("stream_wal_v2: frame read error: %v", err)
+			}
+			return
+		}
+
+		var req StreamWALRequest
+		resp := StreamWALResponse{}
+		if err := json.Unmarshal(frame, &req); err != nil {
+			resp = StreamWALResponse{Status: "error", Error: "invalid JSON frame"}
+		} else if err := checkTenant(id, req.TenantID); err != nil {
+			resp = StreamWALResponse{Status: "error", Error: err.Error()}
+		} else if walData, err := base64.StdEncoding.DecodeString(req.WALData); err != nil {
+			resp = StreamWALResponse{Status: "error", Error: "invalid base64 wal data"}
+		} else {
+			record := WALRecord{LSN: req.LSN, WALData: walData, SpaceID: req.SpaceID, PageNo: req.PageNo, Tenant: id.Tenant}
+			if err := s.ingestWAL(record); err != nil {
+				resp = StreamWALResponse{Status: "error", Error: err.Error()}
+			} else {
+				resp = StreamWALResponse{Status: "success", LastAppliedLSN: req.LSN}
+			}
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("stream_wal_v2: failed to marshal ack: %v", err)
+			return
+		}
+		if err := writeFrame(w, respBytes); err != nil {
+			log.Printf("stream_wal_v2: failed to write ack: %v", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// walBroadcaster fans out newly ingested WAL records to subscribe_wal
+// tailers. Subscribers that fall behind have records dropped rather than
+// blocking ingestion.
+type walBroadcaster struct {
+	mu   sync.Mutex
+	subs map[chan WALRecord]struct{}
+}
+
+func newWALBroadcaster() *walBroadcaster {
+	return &walBroadcaster{subs: make(map[chan WALRecord]struct{})}
+}
+
+// subscribeWALBufferSize is how many unsent records a slow subscriber can
+// accumulate before new records are dropped for it.
+const subscribeWALBufferSize = 256
+
+func (b *walBroadcaster) subscribe() (<-chan WALRecord, func()) {
+	ch := make(chan WALRecord, subscribeWALBufferSize)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+	return ch, unsubscribe
+}
+
+func (b *walBroadcaster) publish(rec WALRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- rec:
+		default:
+			log.Printf("subscribe_wal: subscriber buffer full, dropping record lsn=%d", rec.LSN)
+		}
+	}
+}
+
+// handleSubscribeWAL handles GET /api/v1/subscribe_wal?start_lsn=N, a
+// server-sent-events style endpoint that first replays every record with
+// LSN > start_lsn still held in an in-memory page chain (records the
+// compactor has already folded are not replayed; see backlogSince) and then
+// tails newly ingested records as they arrive, so a standby page server can catch up and then stay live.
+func (s *PageServer) handleSubscribeWAL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	startLSN, err := parseStartLSN(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, _ := identityFromContext(r.Context())
+
+	// Subscribe before reading the backlog so no record published in
+	// between is missed.
+	live, unsubscribe := s.wal.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	lastSent := startLSN
+	for _, rec := range s.backlogSince(startLSN, id.Tenant) {
+		if err := writeSSERecord(w, rec); err != nil {
+			return
+		}
+		lastSent = rec.LSN
+	}
+	flusher.Flush()
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rec, ok := <-live:
+			if !ok {
+				return
+			}
+			if rec.LSN <= lastSent || rec.Tenant != id.Tenant {
+				continue
+			}
+			if err := writeSSERecord(w, rec); err != nil {
+				return
+			}
+			lastSent = rec.LSN
+			flusher.Flush()
+		}
+	}
+}
+
+func parseStartLSN(r *http.Request) (uint64, error) {
+	v := r.URL.Query().Get("start_lsn")
+	if v == "" {
+		return 0, nil
+	}
+	lsn, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid start_lsn: %w", err)
+	}
+	return lsn, nil
+}
+
+// backlogSince returns every WAL record belonging to tenant with LSN >
+// startLSN still held by an in-memory page chain, sorted ascending by LSN.
+// It reads from the live pages map rather than the WALStore so a record is
+// visible to a new subscriber the instant it's applied, without waiting on
+// the store's fsync batching. Records already folded into a base image by
+// the compactor are not replayable this way — a subscriber that falls
+// behind compaction needs to catch up from a fresher start_lsn or a page
+// snapshot instead.
+func (s *PageServer) backlogSince(startLSN uint64, tenant string) []WALRecord {
+	s.mu.RLock()
+	chains := make([]*pageChain, 0, len(s.pages))
+	for key, c := range s.pages {
+		if key.Tenant == tenant {
+			chains = append(chains, c)
+		}
+	}
+	s.mu.RUnlock()
+
+	var out []WALRecord
+	for _, c := range chains {
+		c.mu.RLock()
+		for _, rec := range c.records {
+			if rec.LSN > startLSN {
+				out = append(out, rec)
+			}
+		}
+		c.mu.RUnlock()
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].LSN < out[j].LSN })
+	return out
+}
+
+func writeSSERecord(w io.Writer, rec WALRecord) error {
+	payload, err := json.Marshal(StreamWALRequest{
+		LSN:     rec.LSN,
+		WALData: base64.StdEncoding.EncodeToString(rec.WALData),
+		SpaceID: rec.SpaceID,
+		PageNo:  rec.PageNo,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", rec.LSN, payload)
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	want := []byte(`{"lsn":1}`)
+	if err := writeFrame(&buf, want); err != nil {
+		t.Fatalf("writeFrame: %v", err)
+	}
+
+	got, err := readFrame(&buf)
+	if err != nil {
+		t.Fatalf("readFrame: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("readFrame = %q, want %q", got, want)
+	}
+}
+
+func TestReadFrameRejectsOversizedLength(t *testing.T) {
+	var buf bytes.Buffer
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], maxFrameBytes+1)
+	buf.Write(lenBuf[:])
+
+	if _, err := readFrame(&buf); err == nil {
+		t.Fatal("readFrame should reject a claimed length over maxFrameBytes without reading it")
+	}
+}
+
+func TestWALBroadcasterPublishFansOutToSubscribers(t *testing.T) {
+	b := newWALBroadcaster()
+
+	ch1, unsub1 := b.subscribe()
+	defer unsub1()
+	ch2, unsub2 := b.subscribe()
+	defer unsub2()
+
+	rec := WALRecord{LSN: 1, SpaceID: 1, PageNo: 1, Tenant: "t1"}
+	b.publish(rec)
+
+	for _, ch := range []<-chan WALRecord{ch1, ch2} {
+		select {
+		case got := <-ch:
+			if got.LSN != rec.LSN {
+				t.Fatalf("got LSN %d, want %d", got.LSN, rec.LSN)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("subscriber never received the published record")
+		}
+	}
+}
+
+func TestWALBroadcasterDropsRatherThanBlocksSlowSubscriber(t *testing.T) {
+	b := newWALBroadcaster()
+	ch, unsub := b.subscribe()
+	defer unsub()
+
+	// Fill the subscriber's buffer past capacity; publish must never block
+	// on a slow reader, it just drops the overflow (see walBroadcaster doc).
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < subscribeWALBufferSize+10; i++ {
+			b.publish(WALRecord{LSN: uint64(i)})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish blocked instead of dropping records for a full subscriber buffer")
+	}
+
+	if len(ch) != subscribeWALBufferSize {
+		t.Fatalf("buffered channel len = %d, want it full at %d", len(ch), subscribeWALBufferSize)
+	}
+}
+
+func TestBacklogSinceFiltersByTenantAndLSN(t *testing.T) {
+	s := newTestPageServer(t)
+
+	records := []WALRecord{
+		{LSN: 1, SpaceID: 1, PageNo: 1, Tenant: "t1", WALData: []byte("a")},
+		{LSN: 2, SpaceID: 1, PageNo: 1, Tenant: "t1", WALData: []byte("b")},
+		{LSN: 3, SpaceID: 1, PageNo: 2, Tenant: "t2", WALData: []byte("c")},
+	}
+	for _, rec := range records {
+		if err := s.ingestWAL(rec); err != nil {
+			t.Fatalf("ingestWAL: %v", err)
+		}
+	}
+
+	got := s.backlogSince(1, "t1")
+	if len(got) != 1 || got[0].LSN != 2 {
+		t.Fatalf("backlogSince(1, t1) = %+v, want only lsn=2", got)
+	}
+
+	got = s.backlogSince(0, "t2")
+	if len(got) != 1 || got[0].LSN != 3 {
+		t.Fatalf("backlogSince(0, t2) = %+v, want only lsn=3", got)
+	}
+}
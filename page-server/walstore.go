@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"io"
+)
+
+// SegmentInfo describes one WAL segment, sealed or active, for listing and
+// replication purposes.
+type SegmentInfo struct {
+	ID        uint64 `json:"id"`
+	StartLSN  uint64 `json:"start_lsn"`
+	EndLSN    uint64 `json:"end_lsn"`
+	SizeBytes int64  `json:"size_bytes"`
+	Sealed    bool   `json:"sealed"`
+}
+
+// WALStore is the durability layer beneath handleStreamWAL. The in-memory
+// walRecords slice it replaces was unbounded and lost on restart; a WALStore
+// implementation persists records to segments and lets PageServer rebuild
+// its LSN index from them on startup.
+type WALStore interface {
+	// Append queues rec for persistence, and may return before rec has
+	// reached the store's underlying medium or been fsynced: implementations
+	// may buffer and batch several Append calls between fsyncs for throughput, so a
+	// record this call returns success for is not guaranteed crash-safe
+	// until a later Append (or Close) has forced that batch to disk.
+	Append(rec WALRecord) error
+
+	// Segments lists every segment this store knows about, sealed or
+	// active, oldest first.
+	Segments() ([]SegmentInfo, error)
+
+	// ReadSegment returns the raw bytes of segment id for a replica to
+	// pull. The caller must Close the returned reader.
+	ReadSegment(id uint64) (io.ReadCloser, error)
+
+	// Recover replays every durable record in LSN order, e.g. at startup.
+	Recover() ([]WALRecord, error)
+
+	// Retain advances the retention watermark to safeLSN; segments whose
+	// records are all at or below it may be deleted.
+	Retain(safeLSN uint64) error
+
+	Close() error
+}
+
+// ObjectStore is the narrow surface newObjectWALStore needs from an object
+// storage client. Callers inject a concrete S3 or GCS-backed implementation;
+// this package has none of its own.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
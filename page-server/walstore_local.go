@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// segmentMagic identifies a WAL segment file; segmentVersion lets the format
+// evolve without breaking Recover on old segments. Version 2 added a
+// tenant field to each record; version 1 segments are still readable and
+// replay with an empty tenant (the pre-multi-tenancy default).
+const (
+	segmentMagic       = uint32(0x57414c53) // "WALS"
+	segmentVersion     = uint32(2)
+	segmentHeaderBytes = 16 // magic(4) + version(4) + id(8)
+
+	// defaultSegmentMaxBytes bounds how large a single segment file grows
+	// before it is sealed and a new one is opened.
+	defaultSegmentMaxBytes = 64 << 20
+
+	// fsyncBatchSize is how many Append calls accumulate before the active
+	// segment is fsynced, trading a little durability latency for
+	// throughput under bursty WAL traffic.
+	fsyncBatchSize = 32
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// localWALStore persists WAL records to fixed-size, numbered segment files
+// on local disk. Each segment starts with a small header and holds a
+// sequence of CRC32C-checksummed records.
+type localWALStore struct {
+	dir             string
+	segmentMaxBytes int64
+
+	// onSeal, if set, is invoked with the id and path of a segment right
+	// after it is sealed (closed for writing), synchronously and while mu
+	// is held: it must return quickly (e.g. handing off to a goroutine)
+	// rather than doing any slow work like a network upload itself.
+	// objectWALStore uses this to upload sealed segments in the background
+	// and keep only the active tail on disk.
+	onSeal func(id uint64, path string)
+
+	mu             sync.Mutex
+	activeID       uint64
+	activeFile     *os.File
+	activeWriter   *bufio.Writer
+	activeSize     int64
+	activeStartLSN uint64
+	activeEndLSN   uint64
+	pendingSyncs   int
+}
+
+func segmentPath(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("wal-%020d.seg", id))
+}
+
+// newLocalWALStore opens dir (creating it if needed) and resumes appending
+// to the highest-numbered segment found there (or opens the next one if
+// that segment is already full), opening a fresh segment 1 if the directory
+// is empty.
+func newLocalWALStore(dir string, segmentMaxBytes int64) (*localWALStore, error) {
+	if segmentMaxBytes <= 0 {
+		segmentMaxBytes = defaultSegmentMaxBytes
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create wal dir: %w", err)
+	}
+
+	s := &localWALStore{dir: dir, segmentMaxBytes: segmentMaxBytes}
+
+	ids, err := listSegmentIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	nextID := uint64(1)
+	if len(ids) > 0 {
+		nextID = ids[len(ids)-1]
+		if info, err := os.Stat(segmentPath(dir, nextID)); err == nil && info.Size() >= segmentMaxBytes {
+			nextID++
+		}
+	}
+	if err := s.openSegment(nextID); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func listSegmentIDs(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read wal dir: %w", err)
+	}
+	var ids []uint64
+	for _, e := range entries {
+		var id uint64
+		if _, err := fmt.Sscanf(e.Name(), "wal-%020d.seg", &id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// openSegment creates (or resumes appending to) segment id as the active
+// segment. Callers must hold s.mu.
+func (s *localWALStore) openSegment(id uint64) error {
+	path := segmentPath(s.dir, id)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open segment %d: %w", id, err)
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("seek segment %d: %w", id, err)
+	}
+
+	startLSN, endLSN := uint64(0), uint64(0)
+	if size == 0 {
+		if err := writeSegmentHeader(f, id); err != nil {
+			f.Close()
+			return err
+		}
+		size = segmentHeaderBytes
+	} else {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return fmt.Errorf("seek segment %d: %w", id, err)
+		}
+		recs, validSize, err := readSegmentRecords(f)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("replay segment %d: %w", id, err)
+		}
+		if len(recs) > 0 {
+			startLSN = recs[0].LSN
+			endLSN = recs[len(recs)-1].LSN
+		}
+		// A truncated or corrupt trailing record from a prior crash stops
+		// readSegmentRecords before the raw end of the file; resuming writes
+		// there (rather than at validSize) would leave that garbage in
+		// place and make every record appended after it unrecoverable,
+		// since replay is strictly sequential and stops at the first bad
+		// record. Truncate it away before we start writing again.
+		if validSize < size {
+			if err := f.Truncate(validSize); err != nil {
+				f.Close()
+				return fmt.Errorf("truncate segment %d to last valid record: %w", id, err)
+			}
+			size = validSize
+		}
+		if _, err := f.Seek(validSize, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	s.activeID = id
+	s.activeFile = f
+	s.activeWriter = bufio.NewWriter(f)
+	s.activeSize = size
+	s.activeStartLSN = startLSN
+	s.activeEndLSN = endLSN
+	return nil
+}
+
+func writeSegmentHeader(f *os.File, id uint64) error {
+	var hdr [segmentHeaderBytes]byte
+	binary.BigEndian.PutUint32(hdr[0:4], segmentMagic)
+	binary.BigEndian.PutUint32(hdr[4:8], segmentVersion)
+	binary.BigEndian.PutUint64(hdr[8:16], id)
+	_, err := f.Write(hdr[:])
+	return err
+}
+
+// recordFixedBytes is the size of a v2 record header: lsn(8) + space(4) +
+// page(4) + tenantLen(2) + dataLen(4) + crc32c(4).
+const recordFixedBytes = 26
+
+// encodeRecord serializes rec as
+// [lsn u64][space u32][page u32][tenantLen u16][dataLen u32][crc32c u32][tenant][data],
+// with the checksum computed over the tenant and data bytes together.
+func encodeRecord(rec WALRecord) []byte {
+	tenant := []byte(rec.Tenant)
+	buf := make([]byte, recordFixedBytes+len(tenant)+len(rec.WALData))
+	binary.BigEndian.PutUint64(buf[0:8], rec.LSN)
+	binary.BigEndian.PutUint32(buf[8:12], rec.SpaceID)
+	binary.BigEndian.PutUint32(buf[12:16], rec.PageNo)
+	binary.BigEndian.PutUint16(buf[16:18], uint16(len(tenant)))
+	binary.BigEndian.PutUint32(buf[18:22], uint32(len(rec.WALData)))
+	body := buf[recordFixedBytes:]
+	copy(body, tenant)
+	copy(body[len(tenant):], rec.WALData)
+	binary.BigEndian.PutUint32(buf[22:26], crc32.Checksum(body, crc32cTable))
+	return buf
+}
+
+// Append writes rec to the active segment, rotating to a new segment if it
+// would exceed segmentMaxBytes, and fsyncs every fsyncBatchSize records.
+func (s *localWALStore) Append(rec WALRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.activeSize >= s.segmentMaxBytes {
+		if err := s.sealActiveLocked(); err != nil {
+			return err
+		}
+		if err := s.openSegment(s.activeID + 1); err != nil {
+			return err
+		}
+	}
+
+	buf := encodeRecord(rec)
+	if _, err := s.activeWriter.Write(buf); err != nil {
+		return fmt.Errorf("append wal record: %w", err)
+	}
+	s.activeSize += int64(len(buf))
+	if s.activeStartLSN == 0 {
+		s.activeStartLSN = rec.LSN
+	}
+	s.activeEndLSN = rec.LSN
+
+	s.pendingSyncs++
+	if s.pendingSyncs >= fsyncBatchSize {
+		return s.syncLocked()
+	}
+	return nil
+}
+
+func (s *localWALStore) syncLocked() error {
+	if err := s.activeWriter.Flush(); err != nil {
+		return fmt.Errorf("flush wal segment: %w", err)
+	}
+	if err := s.activeFile.Sync(); err != nil {
+		return fmt.Errorf("fsync wal segment: %w", err)
+	}
+	s.pendingSyncs = 0
+	return nil
+}
+
+// sealActiveLocked flushes and closes the active segment and, if onSeal is
+// set, reports it as sealed. Callers must hold s.mu.
+func (s *localWALStore) sealActiveLocked() error {
+	if err := s.syncLocked(); err != nil {
+		return err
+	}
+	id, path := s.activeID, s.activeFile.Name()
+	if err := s.activeFile.Close(); err != nil {
+		return fmt.Errorf("close segment %d: %w", id, err)
+	}
+	if s.onSeal != nil {
+		s.onSeal(id, path)
+	}
+	return nil
+}
+
+func (s *localWALStore) Segments() ([]SegmentInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids, err := listSegmentIDs(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]SegmentInfo, 0, len(ids))
+	for _, id := range ids {
+		info, err := os.Stat(segmentPath(s.dir, id))
+		if err != nil {
+			continue
+		}
+		if id == s.activeID {
+			infos = append(infos, SegmentInfo{
+				ID: id, StartLSN: s.activeStartLSN, EndLSN: s.activeEndLSN,
+				SizeBytes: s.activeSize, Sealed: false,
+			})
+			continue
+		}
+		f, err := os.Open(segmentPath(s.dir, id))
+		if err != nil {
+			continue
+		}
+		recs, _, err := readSegmentRecords(f)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		var start, end uint64
+		if len(recs) > 0 {
+			start, end = recs[0].LSN, recs[len(recs)-1].LSN
+		}
+		infos = append(infos, SegmentInfo{ID: id, StartLSN: start, EndLSN: end, SizeBytes: info.Size(), Sealed: true})
+	}
+	return infos, nil
+}
+
+func (s *localWALStore) ReadSegment(id uint64) (io.ReadCloser, error) {
+	s.mu.Lock()
+	if id == s.activeID {
+		if err := s.syncLocked(); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
+	s.mu.Unlock()
+
+	f, err := os.Open(segmentPath(s.dir, id))
+	if err != nil {
+		return nil, fmt.Errorf("open segment %d: %w", id, err)
+	}
+	return f, nil
+}
+
+// readSegmentRecords reads every well-formed record from r, which must be
+// positioned at the start of a segment (header first). It returns the
+// records found and the byte offset at which reading stopped (e.g. a
+// truncated trailing record is silently dropped, matching a writer that
+// crashed mid-append).
+func readSegmentRecords(src io.Reader) ([]WALRecord, int64, error) {
+	r := bufio.NewReader(src)
+
+	var hdr [segmentHeaderBytes]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, 0, fmt.Errorf("read segment header: %w", err)
+	}
+	if binary.BigEndian.Uint32(hdr[0:4]) != segmentMagic {
+		return nil, 0, fmt.Errorf("bad segment magic")
+	}
+	version := binary.BigEndian.Uint32(hdr[4:8])
+
+	var recs []WALRecord
+	offset := int64(segmentHeaderBytes)
+	for {
+		rec, n, ok := readOneRecord(r, version)
+		if !ok {
+			break
+		}
+		recs = append(recs, rec)
+		offset += n
+	}
+	return recs, offset, nil
+}
+
+// readOneRecord reads a single record in the format written by version,
+// returning ok=false on EOF, a truncated trailing record, or a checksum
+// mismatch (all of which mean a writer crashed mid-append and the rest of
+// the segment is discarded).
+func readOneRecord(r *bufio.Reader, version uint32) (rec WALRecord, n int64, ok bool) {
+	if version == 1 {
+		var fixed [24]byte
+		if _, err := io.ReadFull(r, fixed[:]); err != nil {
+			return WALRecord{}, 0, false
+		}
+		length := binary.BigEndian.Uint32(fixed[16:20])
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return WALRecord{}, 0, false
+		}
+		wantCRC := binary.BigEndian.Uint32(fixed[20:24])
+		if crc32.Checksum(data, crc32cTable) != wantCRC {
+			return WALRecord{}, 0, false
+		}
+		rec = WALRecord{
+			LSN:     binary.BigEndian.Uint64(fixed[0:8]),
+			SpaceID: binary.BigEndian.Uint32(fixed[8:12]),
+			PageNo:  binary.BigEndian.Uint32(fixed[12:16]),
+			WALData: data,
+		}
+		return rec, int64(len(fixed)) + int64(length), true
+	}
+
+	var fixed [recordFixedBytes]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return WALRecord{}, 0, false
+	}
+	tenantLen := binary.BigEndian.Uint16(fixed[16:18])
+	dataLen := binary.BigEndian.Uint32(fixed[18:22])
+	body := make([]byte, int(tenantLen)+int(dataLen))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return WALRecord{}, 0, false
+	}
+	wantCRC := binary.BigEndian.Uint32(fixed[22:26])
+	if crc32.Checksum(body, crc32cTable) != wantCRC {
+		return WALRecord{}, 0, false
+	}
+	rec = WALRecord{
+		LSN:     binary.BigEndian.Uint64(fixed[0:8]),
+		SpaceID: binary.BigEndian.Uint32(fixed[8:12]),
+		PageNo:  binary.BigEndian.Uint32(fixed[12:16]),
+		Tenant:  string(body[:tenantLen]),
+		WALData: body[tenantLen:],
+	}
+	return rec, int64(len(fixed)) + int64(len(body)), true
+}
+
+// Recover replays every durable record across all segments in order.
+func (s *localWALStore) Recover() ([]WALRecord, error) {
+	ids, err := listSegmentIDs(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []WALRecord
+	for _, id := range ids {
+		f, err := os.Open(segmentPath(s.dir, id))
+		if err != nil {
+			return nil, fmt.Errorf("open segment %d: %w", id, err)
+		}
+		recs, _, err := readSegmentRecords(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("recover segment %d: %w", id, err)
+		}
+		all = append(all, recs...)
+	}
+	return all, nil
+}
+
+// Retain deletes every sealed segment whose highest LSN is at or below
+// safeLSN. The active segment is never deleted.
+func (s *localWALStore) Retain(safeLSN uint64) error {
+	infos, err := s.Segments()
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.Sealed && info.EndLSN != 0 && info.EndLSN <= safeLSN {
+			if err := os.Remove(segmentPath(s.dir, info.ID)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("remove segment %d: %w", info.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *localWALStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.syncLocked(); err != nil {
+		return err
+	}
+	return s.activeFile.Close()
+}
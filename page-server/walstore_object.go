@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// objectWALStore wraps a localWALStore for the active tail and uploads each
+// segment to an ObjectStore in the background as soon as it seals, deleting
+// the local copy afterward so only the active segment occupies local disk.
+type objectWALStore struct {
+	local  *localWALStore
+	store  ObjectStore
+	prefix string
+	ctx    context.Context
+
+	mu       sync.Mutex
+	uploaded map[uint64]bool // segment ids confirmed durable in the object store
+
+	uploadWG sync.WaitGroup // in-flight background uploads; Close waits on this
+}
+
+// newObjectWALStore creates a store that keeps its active segment in
+// localDir and seals finished segments into store under prefix (e.g.
+// "tenant-42/wal/"). ctx bounds the background uploads issued as segments
+// seal; pass context.Background() for a store that should keep running for
+// the life of the process.
+func newObjectWALStore(ctx context.Context, localDir string, segmentMaxBytes int64, store ObjectStore, prefix string) (*objectWALStore, error) {
+	local, err := newLocalWALStore(localDir, segmentMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &objectWALStore{local: local, store: store, prefix: prefix, ctx: ctx, uploaded: make(map[uint64]bool)}
+	local.onSeal = s.handleSeal
+	return s, nil
+}
+
+func (s *objectWALStore) objectKey(id uint64) string {
+	return fmt.Sprintf("%ssegments/wal-%020d.seg", s.prefix, id)
+}
+
+// handleSeal is localWALStore's onSeal callback, invoked synchronously
+// while localWALStore.mu is held. It must return quickly: the actual
+// upload runs in its own goroutine so a slow or hung object store blocks
+// only this one sealing rotation, not every Append/Segments/ReadSegment
+// call across every tenant while the upload is in flight.
+func (s *objectWALStore) handleSeal(id uint64, path string) {
+	s.uploadWG.Add(1)
+	go s.uploadSealedSegment(id, path)
+}
+
+// uploadSealedSegment uploads a just-sealed local segment and removes it
+// from disk, keeping only the active tail local as required.
+func (s *objectWALStore) uploadSealedSegment(id uint64, path string) {
+	defer s.uploadWG.Done()
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("wal: failed to open sealed segment %d for upload: %v", id, err)
+		return
+	}
+	defer f.Close()
+
+	if err := s.store.Put(s.ctx, s.objectKey(id), f); err != nil {
+		log.Printf("wal: failed to upload sealed segment %d: %v", id, err)
+		return
+	}
+
+	s.mu.Lock()
+	s.uploaded[id] = true
+	s.mu.Unlock()
+
+	if err := os.Remove(path); err != nil {
+		log.Printf("wal: uploaded segment %d but failed to remove local copy: %v", id, err)
+	}
+}
+
+func (s *objectWALStore) Append(rec WALRecord) error {
+	return s.local.Append(rec)
+}
+
+func (s *objectWALStore) Segments() ([]SegmentInfo, error) {
+	local, err := s.local.Segments()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.store.List(s.ctx, s.prefix+"segments/")
+	if err != nil {
+		return nil, fmt.Errorf("list uploaded segments: %w", err)
+	}
+
+	byID := make(map[uint64]SegmentInfo, len(local))
+	for _, info := range local {
+		byID[info.ID] = info
+	}
+	for _, key := range keys {
+		var id uint64
+		name := key[strings.LastIndex(key, "/")+1:]
+		if _, err := fmt.Sscanf(name, "wal-%020d.seg", &id); err != nil {
+			continue
+		}
+		if _, exists := byID[id]; !exists {
+			byID[id] = SegmentInfo{ID: id, Sealed: true}
+		}
+	}
+
+	infos := make([]SegmentInfo, 0, len(byID))
+	for _, info := range byID {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos, nil
+}
+
+func (s *objectWALStore) ReadSegment(id uint64) (io.ReadCloser, error) {
+	s.mu.Lock()
+	_, stillLocal := s.uploaded[id]
+	s.mu.Unlock()
+
+	if !stillLocal {
+		if r, err := s.local.ReadSegment(id); err == nil {
+			return r, nil
+		}
+	}
+	return s.store.Get(s.ctx, s.objectKey(id))
+}
+
+// Recover replays sealed segments pulled from the object store followed by
+// whatever remains in the local active tail.
+func (s *objectWALStore) Recover() ([]WALRecord, error) {
+	infos, err := s.Segments()
+	if err != nil {
+		return nil, err
+	}
+
+	var all []WALRecord
+	for _, info := range infos {
+		r, err := s.ReadSegment(info.ID)
+		if err != nil {
+			return nil, fmt.Errorf("fetch segment %d: %w", info.ID, err)
+		}
+		recs, _, err := readSegmentRecords(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("recover segment %d: %w", info.ID, err)
+		}
+		all = append(all, recs...)
+	}
+	return all, nil
+}
+
+func (s *objectWALStore) Retain(safeLSN uint64) error {
+	infos, err := s.Segments()
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.Sealed && info.EndLSN != 0 && info.EndLSN <= safeLSN {
+			if err := s.store.Delete(s.ctx, s.objectKey(info.ID)); err != nil {
+				return fmt.Errorf("delete segment %d: %w", info.ID, err)
+			}
+		}
+	}
+	return s.local.Retain(safeLSN)
+}
+
+// Close waits for any background uploads kicked off by handleSeal to
+// finish before closing the local store, so a shutdown doesn't silently
+// abandon a segment mid-upload.
+func (s *objectWALStore) Close() error {
+	s.uploadWG.Wait()
+	return s.local.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// blockingObjectStore is an ObjectStore whose Put blocks until the test
+// releases it, used to simulate a slow or hung upload.
+type blockingObjectStore struct {
+	putStarted chan struct{}
+	release    chan struct{}
+}
+
+func (b *blockingObjectStore) Put(ctx context.Context, key string, r io.Reader) error {
+	close(b.putStarted)
+	<-b.release
+	return nil
+}
+
+func (b *blockingObjectStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return nil, io.EOF
+}
+
+func (b *blockingObjectStore) List(ctx context.Context, prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func (b *blockingObjectStore) Delete(ctx context.Context, key string) error {
+	return nil
+}
+
+// TestObjectWALStoreUploadDoesNotBlockAppend is a regression test for the
+// bug where handleSeal uploaded synchronously from inside sealActiveLocked,
+// which runs while localWALStore.mu is held: a slow object store would
+// stall every Append across every tenant for as long as the upload took.
+func TestObjectWALStoreUploadDoesNotBlockAppend(t *testing.T) {
+	blocking := &blockingObjectStore{putStarted: make(chan struct{}), release: make(chan struct{})}
+	// segmentMaxBytes=1 forces the very first Append to see the active
+	// segment (header already written by newLocalWALStore) as over
+	// capacity, triggering an immediate seal in that call, which hands the
+	// upload off to a background goroutine.
+	store, err := newObjectWALStore(context.Background(), t.TempDir(), 1, blocking, "")
+	if err != nil {
+		t.Fatalf("newObjectWALStore: %v", err)
+	}
+
+	appendDone := make(chan error, 1)
+	go func() {
+		appendDone <- store.Append(WALRecord{LSN: 1, SpaceID: 1, PageNo: 1, Tenant: "a", WALData: []byte("x")})
+	}()
+
+	select {
+	case <-blocking.putStarted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upload never started")
+	}
+
+	select {
+	case err := <-appendDone:
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Append blocked on the in-flight upload instead of returning once the seal was handed off")
+	}
+
+	close(blocking.release)
+
+	closeDone := make(chan error, 1)
+	go func() { closeDone <- store.Close() }()
+	select {
+	case err := <-closeDone:
+		if err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not wait for the background upload to finish")
+	}
+}